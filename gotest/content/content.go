@@ -0,0 +1,110 @@
+// Package content wraps the DHT's provide/find-providers calls and adds a
+// small custom stream protocol for actually fetching the bytes behind a CID
+// once a provider has been found, so Spartic nodes can act as real content
+// routers instead of just pinging each other.
+package content
+
+import (
+    "context"
+    "fmt"
+    "io"
+
+    "github.com/ipfs/go-cid"
+    ds "github.com/ipfs/go-datastore"
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+    multihash "github.com/multiformats/go-multihash"
+)
+
+/// ResolveProtocolID identifies the stream protocol Spartic nodes use to
+/// serve the bytes behind a CID to a peer that asks for them.
+const ResolveProtocolID protocol.ID = "/spartic/resolve/1.0.0"
+
+func blockKey(c cid.Cid) ds.Key {
+    return ds.NewKey("content/" + c.String())
+}
+
+/// Provide announces to the DHT that this node can serve the given CID.
+func Provide(ctx context.Context, dhtInstance *dht.IpfsDHT, c cid.Cid) error {
+    if err := dhtInstance.Provide(ctx, c, true); err != nil {
+        return fmt.Errorf("could not provide %s: %w", c, err)
+    }
+    return nil
+}
+
+/// FindProviders asks the DHT for up to n peers that claim to be able to
+/// serve the given CID.
+func FindProviders(ctx context.Context, dhtInstance *dht.IpfsDHT, c cid.Cid, n int) ([]peer.AddrInfo, error) {
+    providerChan := dhtInstance.FindProvidersAsync(ctx, c, n)
+    providers := make([]peer.AddrInfo, 0, n)
+    for addrInfo := range providerChan {
+        providers = append(providers, addrInfo)
+    }
+    return providers, nil
+}
+
+/// RegisterResolveHandler installs a stream handler on the given host that
+/// serves locally-stored blocks to peers that ask for them by CID.
+func RegisterResolveHandler(h host.Host, store ds.Datastore) {
+    h.SetStreamHandler(ResolveProtocolID, func(s network.Stream) {
+        defer s.Close()
+        cidBytes, err := io.ReadAll(s)
+        if err != nil {
+            return
+        }
+        _, c, err := cid.CidFromBytes(cidBytes)
+        if err != nil {
+            return
+        }
+        data, err := store.Get(context.Background(), blockKey(c))
+        if err != nil {
+            return
+        }
+        s.Write(data)
+    })
+}
+
+/// Store saves the given block's bytes locally under its CID, so that this
+/// node can later provide and serve it.
+func Store(ctx context.Context, store ds.Datastore, c cid.Cid, data []byte) error {
+    if err := store.Put(ctx, blockKey(c), data); err != nil {
+        return fmt.Errorf("could not store block %s: %w", c, err)
+    }
+    return nil
+}
+
+/// ComputeCid hashes the given bytes into the CIDv1 that Store, Provide, and
+/// Resolve all use to identify them.
+func ComputeCid(data []byte) (cid.Cid, error) {
+    hash, err := multihash.Sum(data, multihash.SHA2_256, -1)
+    if err != nil {
+        return cid.Undef, fmt.Errorf("could not hash content: %w", err)
+    }
+    return cid.NewCidV1(cid.Raw, hash), nil
+}
+
+/// Resolve fetches the bytes behind a CID from the given peer, which is
+/// expected to be running RegisterResolveHandler.
+func Resolve(ctx context.Context, h host.Host, p peer.ID, c cid.Cid) ([]byte, error) {
+    s, err := h.NewStream(ctx, p, ResolveProtocolID)
+    if err != nil {
+        return nil, fmt.Errorf("could not open resolve stream to %s: %w", p, err)
+    }
+    defer s.Close()
+
+    if _, err := s.Write(c.Bytes()); err != nil {
+        return nil, fmt.Errorf("could not send CID to %s: %w", p, err)
+    }
+    if err := s.CloseWrite(); err != nil {
+        return nil, fmt.Errorf("could not finish sending CID to %s: %w", p, err)
+    }
+
+    data, err := io.ReadAll(s)
+    if err != nil {
+        return nil, fmt.Errorf("could not read block from %s: %w", p, err)
+    }
+    return data, nil
+}
@@ -1,23 +1,52 @@
 package main
 
 import (
+    "bufio"
     "context"
+    "encoding/json"
+    "flag"
     "fmt"
     "os"
     "os/signal"
+    "path/filepath"
     "strings"
     "syscall"
+    "time"
     "github.com/libp2p/go-libp2p"
     "github.com/libp2p/go-libp2p/core/crypto"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/network"
     "github.com/libp2p/go-libp2p/core/peerstore"
+    "github.com/libp2p/go-libp2p/core/protocol"
     "github.com/libp2p/go-libp2p/p2p/host/routed"
     "github.com/libp2p/go-libp2p/p2p/protocol/ping"
+    discoveryrouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+    discoveryutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+    libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+    libp2ptcp "github.com/libp2p/go-libp2p/p2p/transport/tcp"
+    libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+    "github.com/libp2p/go-libp2p/p2p/security/noise"
+    connmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+    "github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
     dht "github.com/libp2p/go-libp2p-kad-dht"
+    pubsub "github.com/libp2p/go-libp2p-pubsub"
     multiaddr "github.com/multiformats/go-multiaddr"
+    "github.com/ipfs/go-cid"
     "github.com/ipfs/go-ds-leveldb"
     ds "github.com/ipfs/go-datastore"
+    dsq "github.com/ipfs/go-datastore/query"
+    "github.com/interfect/spartic/gotest/content"
+    "github.com/interfect/spartic/gotest/metrics"
+    logging "github.com/ipfs/go-log/v2"
+    "gopkg.in/yaml.v3"
+)
+
+// Per-subsystem loggers. --loglevel sets the same level on all three.
+var (
+    nodeLog = logging.Logger("spartic.node")
+    dhtLog  = logging.Logger("spartic.dht")
+    pingLog = logging.Logger("spartic.ping")
 )
 
 /// Load a private key from the given datastore, or make a new one and store it in there.
@@ -52,57 +81,465 @@ func loadOrMakeKey(ctx context.Context, store ds.Datastore) (crypto.PrivKey, err
     return privKey, nil
 }
 
+/// NodeConfig holds the operator-configurable knobs for makeNode, as opposed
+/// to the things (like the private key) that live in the datastore itself.
+type NodeConfig struct {
+    // Bootstrap is the list of peers to dial when joining the DHT. If empty,
+    // the public IPFS default bootstrap peers are used instead.
+    Bootstrap []peer.AddrInfo
+    // Network, if set, is prefixed onto the DHT protocol ID, so operators can
+    // run an isolated Spartic swarm that won't cross-talk with the public
+    // IPFS DHT or with Spartic swarms using a different network ID.
+    Network string
+    // BootstrapMode runs this node's DHT in dht.ModeServer instead of the
+    // default auto-detected mode, so it always serves as a bootstrap peer
+    // for other nodes instead of just joining as a client.
+    BootstrapMode bool
+    // ListenAddrs are the multiaddrs to listen on. If empty, we listen on
+    // the default TCP and QUIC addresses on all interfaces.
+    ListenAddrs []string
+    // ConnLow and ConnHigh are the low and high watermarks for the
+    // connection manager, which trims connections once ConnHigh is exceeded
+    // until ConnLow remains.
+    ConnLow, ConnHigh int
+    // ConnGracePeriod is how long a newly-opened connection is protected
+    // from being trimmed by the connection manager.
+    ConnGracePeriod time.Duration
+    // PeerTTL is how long the bootstrap peers' addresses are kept in the
+    // persistent peerstore before expiring. Addresses learned any other way
+    // (discovery, DHT lookups, etc.) keep the TTLs libp2p gives them.
+    PeerTTL time.Duration
+}
+
+/// peerTTL returns the configured peer address TTL, or peerstore.PermanentAddrTTL
+/// if none was configured, preserving the old behavior.
+func (c NodeConfig) peerTTL() time.Duration {
+    if c.PeerTTL != 0 {
+        return c.PeerTTL
+    }
+    return peerstore.PermanentAddrTTL
+}
+
+/// bootstrapPeers returns the configured bootstrap peers, or the public IPFS
+/// defaults if none were configured.
+func (c NodeConfig) bootstrapPeers() []peer.AddrInfo {
+    if len(c.Bootstrap) > 0 {
+        return c.Bootstrap
+    }
+    return dht.GetDefaultBootstrapPeerAddrInfos()
+}
+
+/// defaultListenAddrs are the addresses we listen on when the operator
+/// doesn't configure any: TCP and QUIC, on all interfaces, on the default
+/// libp2p port.
+var defaultListenAddrs = []string{
+    "/ip4/0.0.0.0/tcp/4001",
+    "/ip4/0.0.0.0/udp/4001/quic",
+}
+
+/// listenAddrs returns the configured listen addresses, or defaultListenAddrs
+/// if none were configured.
+func (c NodeConfig) listenAddrs() []string {
+    if len(c.ListenAddrs) > 0 {
+        return c.ListenAddrs
+    }
+    return defaultListenAddrs
+}
+
 /// Make a libp2p host with all the cool features we want.
 /// Loads key from the given data store, and uses it for the DHT.
 /// Registers with bootstrap peers under our peer ID.
-func makeNode(ctx context.Context, store ds.Batching) (host.Host, *dht.IpfsDHT, error) {
+/// Also stands up a GossipSub PubSub instance on the node, so that
+/// this and future subsystems (content routing, consensus, etc.) can
+/// all share one pubsub handle instead of each making their own.
+func makeNode(ctx context.Context, store ds.Batching, cfg NodeConfig) (host.Host, *dht.IpfsDHT, *pubsub.PubSub, error) {
     // Load our key
     privKey, err := loadOrMakeKey(ctx, store)
     if err != nil {
-        return nil, nil, fmt.Errorf("could not load or make key: %w", err)
+        return nil, nil, nil, fmt.Errorf("could not load or make key: %w", err)
+    }
+
+    // Bound how many connections we keep around, so a churning swarm doesn't
+    // grow our memory usage without limit
+    connLow, connHigh := cfg.ConnLow, cfg.ConnHigh
+    if connLow == 0 && connHigh == 0 {
+        connLow, connHigh = 128, 256
+    }
+    connGrace := cfg.ConnGracePeriod
+    if connGrace == 0 {
+        connGrace = time.Minute
+    }
+    connManager, err := connmgr.NewConnManager(connLow, connHigh, connmgr.WithGracePeriod(connGrace))
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("could not make connection manager: %w", err)
+    }
+
+    // Persist discovered peer identities and addresses into the same
+    // datastore as everything else, so we don't have to re-bootstrap from
+    // scratch every time we restart.
+    persistentPeerstore, err := pstoreds.NewPeerstore(ctx, store, pstoreds.DefaultOpts())
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("could not make persistent peerstore: %w", err)
     }
 
-    // Start a libp2p node with that key
+    // Start a libp2p node with that key, offering both TCP and QUIC
+    // transports secured with either TLS or Noise, and a bounded connection count.
     baseNode, err := libp2p.New(
         libp2p.Identity(privKey),
         libp2p.NATPortMap(),
+        libp2p.ListenAddrStrings(cfg.listenAddrs()...),
+        // Registering any Transport() disables go-libp2p's automatic default
+        // transports, so TCP must be added explicitly alongside QUIC.
+        libp2p.Transport(libp2ptcp.NewTCPTransport),
+        libp2p.Transport(libp2pquic.NewTransport),
+        libp2p.Security(libp2ptls.ID, libp2ptls.New),
+        libp2p.Security(noise.ID, noise.New),
+        libp2p.ConnectionManager(connManager),
+        libp2p.Peerstore(persistentPeerstore),
     )
     if err != nil {
-        return nil, nil, fmt.Errorf("could not make base node: %w", err)
+        return nil, nil, nil, fmt.Errorf("could not make base node: %w", err)
     }
-    
-    // Make a DHT around the node
-    dhtInstance, err := dht.New(ctx, baseNode,
-        // Bootstrap with the default peers
-        dht.BootstrapPeersFunc(dht.GetDefaultBootstrapPeerAddrInfos),
+
+    bootstrapPeers := cfg.bootstrapPeers()
+
+    dhtOpts := []dht.Option{
+        // Bootstrap with the configured (or default) peers
+        dht.BootstrapPeers(bootstrapPeers...),
         dht.Datastore(store),
+    }
+    if cfg.Network != "" {
+        // Run our own isolated swarm instead of joining the public IPFS DHT
+        dhtOpts = append(dhtOpts, dht.ProtocolPrefix(protocol.ID("/spartic/"+cfg.Network)))
+    }
+    if cfg.BootstrapMode {
+        // Always serve as a bootstrap/server node, rather than auto-detecting
+        dhtOpts = append(dhtOpts, dht.Mode(dht.ModeServer))
+    }
 
-    )
+    // Make a DHT around the node
+    dhtInstance, err := dht.New(ctx, baseNode, dhtOpts...)
     if err != nil {
-        return nil, nil, fmt.Errorf("could not make DHT: %w", err)
+        return nil, nil, nil, fmt.Errorf("could not make DHT: %w", err)
     }
-    
+
     // And use it to make a routed node
     node := routedhost.Wrap(baseNode, dhtInstance)
-    
+
     // Manually bootstrap
     // We *must* connect to several real nodes or the DHT will just immediately fail to come up.
-    for _, bootstrapAddr := range dht.GetDefaultBootstrapPeerAddrInfos() {
-        node.Peerstore().AddAddrs(bootstrapAddr.ID, bootstrapAddr.Addrs, peerstore.PermanentAddrTTL)
+    for _, bootstrapAddr := range bootstrapPeers {
+        node.Peerstore().AddAddrs(bootstrapAddr.ID, bootstrapAddr.Addrs, cfg.peerTTL())
         if err := node.Connect(ctx, bootstrapAddr); err != nil {
-            fmt.Println("Failed to dial bootstrap peer", bootstrapAddr)
+            dhtLog.Warnw("failed to dial bootstrap peer", "peer", bootstrapAddr, "error", err)
+            metrics.BootstrapAttempts.WithLabelValues("failure").Inc()
         } else {
-            fmt.Println("Connected to bootstrap peer", bootstrapAddr)
+            dhtLog.Infow("connected to bootstrap peer", "peer", bootstrapAddr)
+            metrics.BootstrapAttempts.WithLabelValues("success").Inc()
+            recordPeerSeen(ctx, store, bootstrapAddr.ID)
         }
     }
 
     // Bootstrap the DHT
+    bootstrapStart := time.Now()
     err = dhtInstance.Bootstrap(ctx)
+    metrics.DHTQueryLatency.Observe(time.Since(bootstrapStart).Seconds())
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not bootstrap DHT: %w", err)
+		return nil, nil, nil, fmt.Errorf("could not bootstrap DHT: %w", err)
 	}
-    
-    return node, dhtInstance, nil
+
+    // Make a GossipSub instance riding on top of the same host, so callers
+    // get topic-based messaging for free alongside the DHT.
+    ps, err := pubsub.NewGossipSub(ctx, node)
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("could not make pubsub: %w", err)
+    }
+
+    return node, dhtInstance, ps, nil
+}
+
+/// FileConfig is the shape of the optional JSON or YAML config file passed
+/// with --config. Its values are used as fallbacks for any of the
+/// corresponding flags that were not set on the command line.
+type FileConfig struct {
+    Bootstrap []string `json:"bootstrap" yaml:"bootstrap"`
+    Network   string   `json:"network" yaml:"network"`
+}
+
+/// loadConfig reads a FileConfig from the given path, picking JSON or YAML
+/// decoding based on the file extension (defaulting to JSON).
+func loadConfig(path string) (*FileConfig, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("could not read config file: %w", err)
+    }
+
+    cfg := &FileConfig{}
+    switch filepath.Ext(path) {
+    case ".yaml", ".yml":
+        if err := yaml.Unmarshal(data, cfg); err != nil {
+            return nil, fmt.Errorf("could not parse YAML config file: %w", err)
+        }
+    default:
+        if err := json.Unmarshal(data, cfg); err != nil {
+            return nil, fmt.Errorf("could not parse JSON config file: %w", err)
+        }
+    }
+    return cfg, nil
+}
+
+func seenPeerKey(p peer.ID) ds.Key {
+    return ds.NewKey("peers-seen/" + p.String())
+}
+
+/// recordPeerSeen notes in the datastore that we just saw the given peer, so
+/// the "peers" subcommand can report it with a recent last-seen time.
+func recordPeerSeen(ctx context.Context, store ds.Datastore, p peer.ID) {
+    if err := store.Put(ctx, seenPeerKey(p), []byte(time.Now().Format(time.RFC3339))); err != nil {
+        fmt.Println("Could not record last-seen time for", p, ":", err)
+    }
+}
+
+/// listSeenPeers prints every peer we've ever recorded as seen, along with
+/// the last time we saw it.
+func listSeenPeers(ctx context.Context, store ds.Datastore) error {
+    results, err := store.Query(ctx, dsq.Query{Prefix: "/peers-seen"})
+    if err != nil {
+        return fmt.Errorf("could not query known peers: %w", err)
+    }
+    defer results.Close()
+
+    for entry := range results.Next() {
+        if entry.Error != nil {
+            return fmt.Errorf("could not read known peer: %w", entry.Error)
+        }
+        peerID := strings.TrimPrefix(entry.Key, "/peers-seen/")
+        fmt.Printf("%s\tlast seen %s\n", peerID, string(entry.Value))
+    }
+    return nil
+}
+
+/// parsePeers parses a comma-separated list of multiaddrs or bare peer IDs,
+/// as accepted by parsePeer, into a list of AddrInfos.
+func parsePeers(list string) ([]peer.AddrInfo, error) {
+    if list == "" {
+        return nil, nil
+    }
+    var infos []peer.AddrInfo
+    for _, target := range strings.Split(list, ",") {
+        info, err := parsePeer(strings.TrimSpace(target))
+        if err != nil {
+            return nil, err
+        }
+        infos = append(infos, *info)
+    }
+    return infos, nil
+}
+
+/// Join the named pubsub topic, using the DHT for rendezvous (so peers on
+/// the topic find each other without any hardcoded bootstrap list), and
+/// run a simple chat loop: print inbound messages as they arrive, and
+/// publish whatever is typed on stdin.
+func runChat(ctx context.Context, node host.Host, dhtInstance *dht.IpfsDHT, ps *pubsub.PubSub, topicName string) error {
+    // Use the DHT to advertise and discover other peers interested in this topic
+    routingDiscovery := discoveryrouting.NewRoutingDiscovery(dhtInstance)
+    discoveryutil.Advertise(ctx, routingDiscovery, topicName)
+
+    topicHandle, err := ps.Join(topicName)
+    if err != nil {
+        return fmt.Errorf("could not join topic %s: %w", topicName, err)
+    }
+    sub, err := topicHandle.Subscribe()
+    if err != nil {
+        return fmt.Errorf("could not subscribe to topic %s: %w", topicName, err)
+    }
+
+    // Look for other peers already on the topic and connect to them directly,
+    // so gossip has somewhere to go even before any message is published.
+    go func() {
+        peerChan, err := routingDiscovery.FindPeers(ctx, topicName)
+        if err != nil {
+            fmt.Println("Could not search for topic peers:", err)
+            return
+        }
+        for foundPeer := range peerChan {
+            if foundPeer.ID == node.ID() {
+                continue
+            }
+            if err := node.Connect(ctx, foundPeer); err != nil {
+                fmt.Println("Could not connect to topic peer", foundPeer.ID, ":", err)
+            } else {
+                fmt.Println("Connected to topic peer", foundPeer.ID)
+            }
+        }
+    }()
+
+    // Print inbound messages as they arrive
+    go func() {
+        for {
+            msg, err := sub.Next(ctx)
+            if err != nil {
+                // Context canceled, or the subscription was closed.
+                return
+            }
+            if msg.ReceivedFrom == node.ID() {
+                // Don't echo our own messages back at us
+                continue
+            }
+            fmt.Printf("%s: %s\n", msg.ReceivedFrom, string(msg.Data))
+        }
+    }()
+
+    // Keep the connected-peers gauge current for the life of the chat
+    // session, not just its boot-time value.
+    go func() {
+        ticker := time.NewTicker(10 * time.Second)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                metrics.ConnectedPeers.Set(float64(len(node.Network().Peers())))
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+
+    // Publish whatever is typed on stdin
+    fmt.Println("Joined topic", topicName, "- type a message and press enter to broadcast it")
+    scanner := bufio.NewScanner(os.Stdin)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if line == "" {
+            continue
+        }
+        if err := topicHandle.Publish(ctx, []byte(line)); err != nil {
+            fmt.Println("Could not publish message:", err)
+        }
+    }
+    return scanner.Err()
+}
+
+/// pingResult is the shape of one line of --json daemon output.
+type pingResult struct {
+    Peer  string  `json:"peer"`
+    RTTMs float64 `json:"rtt_ms,omitempty"`
+    TS    string  `json:"ts"`
+    Error string  `json:"error,omitempty"`
+}
+
+/// emitPingResult reports the outcome of a single ping, either as a JSON
+/// line on stdout or as a log line, depending on jsonOutput.
+func emitPingResult(jsonOutput bool, target string, rtt time.Duration, pingErr error) {
+    if jsonOutput {
+        result := pingResult{Peer: target, TS: time.Now().Format(time.RFC3339)}
+        if pingErr != nil {
+            result.Error = pingErr.Error()
+        } else {
+            result.RTTMs = float64(rtt) / float64(time.Millisecond)
+        }
+        data, err := json.Marshal(result)
+        if err != nil {
+            pingLog.Errorw("could not marshal ping result", "error", err)
+            return
+        }
+        fmt.Println(string(data))
+        return
+    }
+    if pingErr != nil {
+        pingLog.Warnw("ping failed", "peer", target, "error", pingErr)
+    } else {
+        pingLog.Infow("ping response", "peer", target, "rtt", rtt)
+    }
+}
+
+/// runDaemon runs continuous health checks against the given target peers,
+/// one round every interval, for count rounds (or forever, if count < 0).
+/// On a failed or missing connection it re-resolves the peer with the DHT
+/// and reconnects, backing off exponentially between repeated failures.
+func runDaemon(ctx context.Context, node host.Host, dhtInstance *dht.IpfsDHT, targets []string, interval time.Duration, count int, jsonOutput bool) error {
+    pingService := &ping.PingService{Host: node}
+    addrInfos := make(map[string]*peer.AddrInfo, len(targets))
+    backoff := make(map[string]time.Duration, len(targets))
+    retryAt := make(map[string]time.Time, len(targets))
+    // One long-lived ping stream/channel per target, reused across rounds,
+    // so we don't orphan a goroutine and a stream every round forever.
+    pingChans := make(map[string]<-chan ping.Result, len(targets))
+
+    for round := 0; count < 0 || round < count; round++ {
+        for _, target := range targets {
+            if until, ok := retryAt[target]; ok && time.Now().Before(until) {
+                // Still serving out this target's backoff; don't hammer it.
+                continue
+            }
+
+            remotePeerInfo, ok := addrInfos[target]
+            if !ok {
+                parsed, err := parsePeer(target)
+                if err != nil {
+                    return fmt.Errorf("could not parse target %q: %w", target, err)
+                }
+                remotePeerInfo = parsed
+                addrInfos[target] = remotePeerInfo
+            }
+
+            if node.Network().Connectedness(remotePeerInfo.ID) != network.Connected {
+                findStart := time.Now()
+                if found, err := dhtInstance.FindPeer(ctx, remotePeerInfo.ID); err == nil {
+                    remotePeerInfo = &found
+                    addrInfos[target] = remotePeerInfo
+                }
+                metrics.DHTQueryLatency.Observe(time.Since(findStart).Seconds())
+
+                if err := node.Connect(ctx, *remotePeerInfo); err != nil {
+                    emitPingResult(jsonOutput, target, 0, fmt.Errorf("could not reconnect: %w", err))
+                    wait := backoff[target] * 2
+                    if wait == 0 {
+                        wait = time.Second
+                    } else if wait > time.Minute {
+                        wait = time.Minute
+                    }
+                    backoff[target] = wait
+                    retryAt[target] = time.Now().Add(wait)
+                    continue
+                }
+                // Fresh connection: drop any old ping stream so we start a new one on it.
+                delete(pingChans, target)
+            }
+            delete(backoff, target)
+            delete(retryAt, target)
+
+            pingChan, ok := pingChans[target]
+            if !ok {
+                pingChan = pingService.Ping(ctx, remotePeerInfo.ID)
+                pingChans[target] = pingChan
+            }
+            res, ok := <-pingChan
+            if !ok {
+                // The underlying stream died; drop it and try again next round.
+                delete(pingChans, target)
+                emitPingResult(jsonOutput, target, 0, fmt.Errorf("ping stream closed"))
+                continue
+            }
+            emitPingResult(jsonOutput, target, res.RTT, res.Error)
+            if res.Error == nil {
+                metrics.PingRTT.Observe(res.RTT.Seconds())
+            }
+        }
+
+        metrics.ConnectedPeers.Set(float64(len(node.Network().Peers())))
+
+        if count >= 0 && round == count-1 {
+            break
+        }
+
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(interval):
+        }
+    }
+    return nil
 }
 
 func parsePeer(target string) (*peer.AddrInfo, error) {
@@ -138,19 +575,107 @@ func main() {
         panic("Please specify a database argument")
     }
 
+    // Flags come after the database argument, and parsing stops at the first
+    // positional argument. The positional arguments are a subcommand and its
+    // operands, the daemon's target peers, or the legacy ping target.
+    flags := flag.NewFlagSet("spartic", flag.ExitOnError)
+    topic := flags.String("topic", "", "join the named pubsub topic and chat over it instead of pinging a peer")
+    bootstrap := flags.String("bootstrap", "", "comma-separated list of bootstrap peer multiaddrs, overriding the public IPFS defaults")
+    network := flags.String("network", "", "network ID to prefix onto the DHT protocol, for running an isolated Spartic swarm")
+    bootstrapMode := flags.Bool("bootstrap-mode", false, "run this node as a DHT server/bootstrap node instead of auto-detecting")
+    configPath := flags.String("config", "", "path to a JSON or YAML config file providing bootstrap peers and network ID")
+    tcpPort := flags.Int("tcp-port", 4001, "TCP port to listen on")
+    quicPort := flags.Int("quic-port", 4001, "QUIC (UDP) port to listen on")
+    connLow := flags.Int("conn-low", 128, "low watermark for the connection manager")
+    connHigh := flags.Int("conn-high", 256, "high watermark for the connection manager")
+    connGrace := flags.Duration("conn-grace", time.Minute, "grace period before a new connection can be trimmed")
+    peerTTL := flags.Duration("peer-ttl", 0, "how long bootstrap peer addresses are kept in the persistent peerstore (0 means forever)")
+    logLevel := flags.String("loglevel", "info", "log level for all spartic subsystems (debug, info, warn, error)")
+    metricsAddr := flags.String("metrics-addr", "", "if set, serve Prometheus metrics on this address (e.g. :2112)")
+    daemon := flags.Bool("daemon", false, "run continuous health checks against target peers (given as positional args) instead of a one-shot ping")
+    daemonInterval := flags.Duration("interval", 10*time.Second, "interval between daemon health-check rounds")
+    daemonCount := flags.Int("count", -1, "number of daemon rounds to run, or -1 to run forever")
+    daemonJSON := flags.Bool("json", false, "emit one JSON line per daemon ping result instead of a log line")
+    flags.Parse(os.Args[2:])
+
+    if err := logging.SetLogLevel("spartic.node", *logLevel); err != nil {
+        panic(err)
+    }
+    if err := logging.SetLogLevel("spartic.dht", *logLevel); err != nil {
+        panic(err)
+    }
+    if err := logging.SetLogLevel("spartic.ping", *logLevel); err != nil {
+        panic(err)
+    }
+
+    if *metricsAddr != "" {
+        go func() {
+            if err := metrics.Serve(*metricsAddr); err != nil {
+                nodeLog.Errorw("metrics server failed", "error", err)
+            }
+        }()
+    }
+
+    // Start from whatever the config file says, then let flags override it
+    nodeCfg := NodeConfig{}
+    if *configPath != "" {
+        fileCfg, err := loadConfig(*configPath)
+        if err != nil {
+            // Fall back to defaults rather than refusing to start
+            nodeLog.Warnw("could not load config file, falling back to defaults", "error", err)
+        } else {
+            if bootstrapPeers, err := parsePeers(strings.Join(fileCfg.Bootstrap, ",")); err != nil {
+                nodeLog.Warnw("could not parse bootstrap peers from config file", "error", err)
+            } else {
+                nodeCfg.Bootstrap = bootstrapPeers
+            }
+            nodeCfg.Network = fileCfg.Network
+        }
+    }
+    if *bootstrap != "" {
+        bootstrapPeers, err := parsePeers(*bootstrap)
+        if err != nil {
+            panic(fmt.Errorf("could not parse --bootstrap: %w", err))
+        }
+        nodeCfg.Bootstrap = bootstrapPeers
+    }
+    if *network != "" {
+        nodeCfg.Network = *network
+    }
+    nodeCfg.BootstrapMode = *bootstrapMode
+    nodeCfg.ListenAddrs = []string{
+        fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", *tcpPort),
+        fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic", *quicPort),
+    }
+    nodeCfg.ConnLow = *connLow
+    nodeCfg.ConnHigh = *connHigh
+    nodeCfg.ConnGracePeriod = *connGrace
+    nodeCfg.PeerTTL = *peerTTL
+
     // Connect to our data stroe that holds stuff like our node keys.
     // We assume it is thread-safe and doesn't need wrapping
     store, err := leveldb.NewDatastore(os.Args[1], &leveldb.Options{})
     if err != nil {
         panic(err)
     }
-    
+
+    // "peers" doesn't need a running node at all, just our local records
+    if flags.Arg(0) == "peers" {
+        if err := listSeenPeers(ctx, store); err != nil {
+            panic(err)
+        }
+        return
+    }
+
     // Load up keys from the datastore and make the node
-    node, dht, err := makeNode(ctx, store)
+    node, dht, ps, err := makeNode(ctx, store, nodeCfg)
     if err != nil {
         panic(err)
     }
-    
+
+    // Serve whatever content blocks we have locally to peers that ask for them
+    content.RegisterResolveHandler(node, store)
+
     // print the node's PeerInfo in multiaddr format
     peerInfo := peer.AddrInfo{
         ID:    node.ID(),
@@ -163,14 +688,129 @@ func main() {
     }
     
     for _, addr := range addrs {
-        fmt.Println("libp2p node address:", addr)
+        nodeLog.Infow("libp2p node address", "address", addr)
+    }
+    metrics.ConnectedPeers.Set(float64(len(node.Network().Peers())))
+
+    // content-routing subcommands, run once and then exit
+    switch flags.Arg(0) {
+    case "add":
+        data, err := os.ReadFile(flags.Arg(1))
+        if err != nil {
+            panic(fmt.Errorf("could not read file %q: %w", flags.Arg(1), err))
+        }
+        c, err := content.ComputeCid(data)
+        if err != nil {
+            panic(err)
+        }
+        if err := content.Store(ctx, store, c, data); err != nil {
+            panic(err)
+        }
+        // This is the actual result of the command, so it goes to stdout, not the log.
+        fmt.Println(c)
+        if err := node.Close(); err != nil {
+            panic(err)
+        }
+        return
+    case "provide":
+        c, err := cid.Decode(flags.Arg(1))
+        if err != nil {
+            panic(fmt.Errorf("could not parse CID %q: %w", flags.Arg(1), err))
+        }
+        if err := content.Provide(ctx, dht, c); err != nil {
+            panic(err)
+        }
+        nodeLog.Infow("providing content", "cid", c)
+        if err := node.Close(); err != nil {
+            panic(err)
+        }
+        return
+    case "findproviders":
+        c, err := cid.Decode(flags.Arg(1))
+        if err != nil {
+            panic(fmt.Errorf("could not parse CID %q: %w", flags.Arg(1), err))
+        }
+        providers, err := content.FindProviders(ctx, dht, c, 20)
+        if err != nil {
+            panic(err)
+        }
+        for _, provider := range providers {
+            addrs, err := peer.AddrInfoToP2pAddrs(&provider)
+            if err != nil {
+                nodeLog.Warnw("found provider with unusable addresses", "peer", provider.ID)
+                continue
+            }
+            for _, addr := range addrs {
+                // This is the actual result of the command, so it goes to stdout, not the log.
+                fmt.Println(addr)
+            }
+        }
+        if err := node.Close(); err != nil {
+            panic(err)
+        }
+        return
+    case "resolve":
+        c, err := cid.Decode(flags.Arg(1))
+        if err != nil {
+            panic(fmt.Errorf("could not parse CID %q: %w", flags.Arg(1), err))
+        }
+        remotePeerInfo, err := parsePeer(flags.Arg(2))
+        if err != nil {
+            panic(err)
+        }
+        if len(remotePeerInfo.Addrs) > 0 {
+            if err := node.Connect(ctx, *remotePeerInfo); err != nil {
+                nodeLog.Warnw("could not connect, continuing anyway", "peer", remotePeerInfo.ID, "error", err)
+            }
+        } else {
+            found, err := dht.FindPeer(ctx, remotePeerInfo.ID)
+            if err != nil {
+                panic(fmt.Errorf("could not find peer %s: %w", remotePeerInfo.ID, err))
+            }
+            remotePeerInfo = &found
+        }
+        data, err := content.Resolve(ctx, node, remotePeerInfo.ID, c)
+        if err != nil {
+            panic(err)
+        }
+        os.Stdout.Write(data)
+        if err := node.Close(); err != nil {
+            panic(err)
+        }
+        return
+    }
+
+    // in daemon mode, treat every positional arg as a target peer to
+    // continuously health-check, instead of pinging a single peer once
+    if *daemon {
+        targets := flags.Args()
+        if len(targets) == 0 {
+            panic("daemon mode requires at least one target peer")
+        }
+        if err := runDaemon(ctx, node, dht, targets, *daemonInterval, *daemonCount, *daemonJSON); err != nil {
+            nodeLog.Warnw("daemon exited", "error", err)
+        }
+        if err := node.Close(); err != nil {
+            panic(err)
+        }
+        return
+    }
+
+    // if a topic was requested, join it and chat over it instead of pinging
+    if *topic != "" {
+        if err := runChat(ctx, node, dht, ps, *topic); err != nil {
+            nodeLog.Warnw("chat session ended", "error", err)
+        }
+        if err := node.Close(); err != nil {
+            panic(err)
+        }
+        return
     }
 
     // if a remote peer has been passed on the command line, connect to it
     // and send it 5 ping messages, otherwise wait for a signal to stop
-    if len(os.Args) > 2 {
-        target := os.Args[2]
-        
+    if target := flags.Arg(0); target != "" {
+
         // Work out who we are meant to connect to
         remotePeerInfo, err := parsePeer(target)
         if err != nil {
@@ -178,48 +818,56 @@ func main() {
         }
         
         if len(remotePeerInfo.Addrs) > 0 {
-            fmt.Println("Connecting directly to", target)
+            nodeLog.Infow("connecting directly", "target", target)
             if err := node.Connect(ctx, *remotePeerInfo); err != nil {
                 // If we have addresses we can make a direct connection right off.
-                fmt.Println("Could not connect:", err, "Continuing anyway.")
+                nodeLog.Warnw("could not connect, continuing anyway", "error", err)
+            } else {
+                recordPeerSeen(ctx, store, remotePeerInfo.ID)
             }
         } else {
-            fmt.Println("Searching for", target)
+            nodeLog.Infow("searching for peer", "target", target)
+            findStart := time.Now()
             found, err := dht.FindPeer(ctx, remotePeerInfo.ID)
+            metrics.DHTQueryLatency.Observe(time.Since(findStart).Seconds())
             if err != nil {
-                fmt.Println("Could not find peer:", err, "Continuing anyway.")
+                nodeLog.Warnw("could not find peer, continuing anyway", "error", err)
             } else {
                 remotePeerInfo = &found
                 if len(remotePeerInfo.Addrs) == 0 {
-                    fmt.Println("Found no addresses for", target)
+                    nodeLog.Warnw("found no addresses for peer", "target", target)
                 }
                 for _, addr := range remotePeerInfo.Addrs {
-                    fmt.Println("Found address:", addr)
+                    nodeLog.Infow("found address", "address", addr)
                 }
                 if err := node.Connect(ctx, *remotePeerInfo); err != nil {
                     // Try connecting after the lookup
-                    fmt.Println("Could not connect:", err, "Continuing anyway.")
+                    nodeLog.Warnw("could not connect, continuing anyway", "error", err)
+                } else {
+                    recordPeerSeen(ctx, store, remotePeerInfo.ID)
                 }
             }
         }
-        
-        fmt.Println("sending 5 ping messages to", remotePeerInfo.ID)
+
+        pingLog.Infow("sending ping messages", "peer", remotePeerInfo.ID, "count", 5)
         pingService := &ping.PingService{Host: node}
         ch := pingService.Ping(ctx, remotePeerInfo.ID)
         for i := 0; i < 5; i++ {
             res := <-ch
             if res.Error != nil {
-                fmt.Println("got ping error!", "Error:", res.Error)
+                pingLog.Warnw("ping failed", "peer", remotePeerInfo.ID, "error", res.Error)
             } else {
-                fmt.Println("got ping response!", "RTT:", res.RTT)
+                pingLog.Infow("ping response", "peer", remotePeerInfo.ID, "rtt", res.RTT)
+                metrics.PingRTT.Observe(res.RTT.Seconds())
             }
         }
+        metrics.ConnectedPeers.Set(float64(len(node.Network().Peers())))
     } else {
         // wait for a SIGINT or SIGTERM signal
         ch := make(chan os.Signal, 1)
         signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
         <-ch
-        fmt.Println("Received signal, shutting down...")
+        nodeLog.Info("received signal, shutting down...")
     }
 
     // shut the node down
@@ -0,0 +1,59 @@
+// Package metrics holds the Prometheus collectors Spartic nodes report on,
+// and the HTTP server that exposes them, so operators can see what a running
+// node is actually doing.
+package metrics
+
+import (
+    "fmt"
+    "net/http"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+    // ConnectedPeers tracks how many peers we are currently connected to.
+    ConnectedPeers = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: "spartic",
+        Name:      "connected_peers",
+        Help:      "Number of peers this node is currently connected to.",
+    })
+
+    // DHTQueryLatency tracks how long DHT operations like FindPeer and
+    // Bootstrap take to complete.
+    DHTQueryLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+        Namespace: "spartic",
+        Name:      "dht_query_latency_seconds",
+        Help:      "Latency of DHT queries.",
+    })
+
+    // PingRTT tracks the round-trip time of ping responses.
+    PingRTT = prometheus.NewHistogram(prometheus.HistogramOpts{
+        Namespace: "spartic",
+        Name:      "ping_rtt_seconds",
+        Help:      "Round-trip time of ping responses.",
+    })
+
+    // BootstrapAttempts counts bootstrap peer dial attempts, labeled by
+    // whether they succeeded or failed.
+    BootstrapAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: "spartic",
+        Name:      "bootstrap_attempts_total",
+        Help:      "Bootstrap peer dial attempts, labeled by outcome.",
+    }, []string{"outcome"})
+)
+
+func init() {
+    prometheus.MustRegister(ConnectedPeers, DHTQueryLatency, PingRTT, BootstrapAttempts)
+}
+
+/// Serve starts an HTTP server on addr exposing the registered metrics at
+/// /metrics. It blocks, so callers typically run it in its own goroutine.
+func Serve(addr string) error {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", promhttp.Handler())
+    if err := http.ListenAndServe(addr, mux); err != nil {
+        return fmt.Errorf("metrics server exited: %w", err)
+    }
+    return nil
+}